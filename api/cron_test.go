@@ -0,0 +1,724 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWaker returns canned statuses by URL and records the apps it was asked
+// to wake.
+type fakeWaker struct {
+	mu       sync.Mutex
+	statuses map[string]string
+	calls    [][]string
+}
+
+func (f *fakeWaker) Wake(ctx context.Context, apps []StreamlitApp) ([]WakeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var urls []string
+	results := make([]WakeResult, len(apps))
+	for i, app := range apps {
+		urls = append(urls, app.URL)
+		results[i] = WakeResult{URL: app.URL, Name: app.Name, Status: f.statuses[app.URL]}
+	}
+	f.calls = append(f.calls, urls)
+	return results, nil
+}
+
+func signedRequest(secret, ts, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/cron", strings.NewReader(body))
+	if ts != "" {
+		r.Header.Set("X-Timestamp", ts)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + body))
+	r.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	current := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		wantErr bool
+	}{
+		{"valid", signedRequest("secret", current, `{"apps":[]}`), false},
+		{"wrong secret", signedRequest("other", current, `{"apps":[]}`), true},
+		{"missing timestamp", signedRequest("secret", "", `{"apps":[]}`), true},
+		{"stale timestamp", signedRequest("secret", stale, `{"apps":[]}`), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySignature(tt.request, "secret", now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifySignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("body restored", func(t *testing.T) {
+		r := signedRequest("secret", current, "payload")
+		if err := verifySignature(r, "secret", now); err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Fatalf("body = %q, want %q", body, "payload")
+		}
+	})
+
+	t.Run("missing signature", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/cron", strings.NewReader(""))
+		r.Header.Set("X-Timestamp", current)
+		if err := verifySignature(r, "secret", now); err == nil {
+			t.Fatal("verifySignature() accepted a request without X-Signature")
+		}
+	})
+}
+
+func TestValidBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"Bearer token", true},
+		{"Bearer wrong", false},
+		{"token", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/cron", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if got := validBearerToken(r, "token"); got != tt.want {
+			t.Errorf("validBearerToken(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Fatal("newRateLimiter(0) should return nil")
+	}
+	var none *rateLimiter
+	if err := none.Wait(context.Background()); err != nil {
+		t.Fatalf("nil limiter Wait() = %v", err)
+	}
+
+	limiter := newRateLimiter(20)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first token is free; the next two are 50ms apart
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("3 waits at 20/s took %s, want at least 100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := newRateLimiter(0.1)
+	slow.Wait(ctx)
+	if err := slow.Wait(ctx); err == nil {
+		t.Fatal("Wait() on a cancelled context should fail")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{" 5 ", 5 * time.Second},
+		{"-1", 0},
+		{"junk", 0},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-30 * time.Second).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseTextApps(t *testing.T) {
+	text := "# apps\n\nhttps://a.streamlit.app\n  b , https://b.streamlit.app  \n"
+	want := []StreamlitApp{
+		{URL: "https://a.streamlit.app"},
+		{Name: "b", URL: "https://b.streamlit.app"},
+	}
+	if got := parseTextApps(text); !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseTextApps() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseCSVApps(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name    string
+		csv     string
+		want    []StreamlitApp
+		wantErr bool
+	}{
+		{
+			name: "all columns",
+			csv:  "Name,URL,group,tags,enabled,notes\n# comment\na,https://a.streamlit.app,demo,x; y,false,ignored\n",
+			want: []StreamlitApp{{Name: "a", URL: "https://a.streamlit.app", Group: "demo", Tags: []string{"x", "y"}, Enabled: &disabled}},
+		},
+		{
+			name: "url only, blank url skipped",
+			csv:  "url\nhttps://a.streamlit.app\n\"\"\n",
+			want: []StreamlitApp{{URL: "https://a.streamlit.app"}},
+		},
+		{name: "missing url column", csv: "name\na\n", wantErr: true},
+		{name: "invalid enabled", csv: "url,enabled\nhttps://a.streamlit.app,maybe\n", wantErr: true},
+		{name: "empty", csv: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCSVApps([]byte(tt.csv))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCSVApps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseCSVApps() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeAppURL(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"", ""},
+		{"x.streamlit.app", "https://x.streamlit.app"},
+		{" HTTPS://X.Streamlit.App/ ", "https://x.streamlit.app"},
+		{"http://x.streamlit.app/Page/", "http://x.streamlit.app/Page"},
+	}
+	for _, tt := range tests {
+		if got := normalizeAppURL(tt.raw); got != tt.want {
+			t.Errorf("normalizeAppURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestGetApp(t *testing.T) {
+	config := &Config{Apps: []StreamlitApp{
+		{Name: "Demo", URL: "https://x.streamlit.app"},
+		{URL: "https://y.streamlit.app"},
+	}}
+	tests := []struct {
+		identifier string
+		want       string
+	}{
+		{"demo", "https://x.streamlit.app"},
+		{"x.streamlit.app", "https://x.streamlit.app"},
+		{"http://X.streamlit.app/", "https://x.streamlit.app"},
+		{"https://y.streamlit.app/", "https://y.streamlit.app"},
+		{"z.streamlit.app", ""},
+	}
+	for _, tt := range tests {
+		app, ok := config.GetApp(tt.identifier)
+		got := ""
+		if ok {
+			got = app.URL
+		}
+		if got != tt.want {
+			t.Errorf("GetApp(%q) = %q, want %q", tt.identifier, got, tt.want)
+		}
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { config.GetApp("http://Y.streamlit.app/") }); allocs != 0 {
+		t.Errorf("GetApp allocated %v times per call, want 0", allocs)
+	}
+}
+
+func TestValidateRejectsDuplicateNames(t *testing.T) {
+	config := &Config{Apps: []StreamlitApp{
+		{Name: "Demo", URL: "https://x.streamlit.app"},
+		{Name: "demo", URL: "https://y.streamlit.app"},
+	}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("Validate() accepted names differing only in case")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	appStates = map[string]*AppState{}
+	defer func() { appStates = map[string]*AppState{} }()
+
+	app := StreamlitApp{URL: "https://a.streamlit.app"}
+	failed := []WakeResult{{URL: app.URL, Status: "error"}}
+	now := time.Now()
+
+	// run wakes or skips the app once and reports whether it was woken
+	run := func(status string) bool {
+		wake, _ := passCircuit([]StreamlitApp{app})
+		if len(wake) == 0 {
+			return false
+		}
+		recordResults([]WakeResult{{URL: app.URL, Status: status}}, now, 0, 2)
+		return true
+	}
+
+	recordResults(failed, now, 0, 2)
+	if got := appStates[app.URL].Circuit; got != CircuitClosed {
+		t.Fatalf("circuit after 1 failure = %s, want closed", got)
+	}
+	recordResults(failed, now, 0, 2)
+	if got := appStates[app.URL]; got.Circuit != CircuitOpen || got.SkipRuns != 1 {
+		t.Fatalf("state after 2 failures = %+v, want open skipping 1 run", got)
+	}
+
+	if run("error") {
+		t.Fatal("open circuit should skip the app")
+	}
+	// Half-open retry fails: reopens for twice as long
+	if !run("error") {
+		t.Fatal("half-open circuit should retry the app")
+	}
+	if got := appStates[app.URL]; got.Circuit != CircuitOpen || got.SkipRuns != 2 {
+		t.Fatalf("state after failed retry = %+v, want open skipping 2 runs", got)
+	}
+
+	if run("awake") || run("awake") {
+		t.Fatal("reopened circuit should skip the app twice")
+	}
+	if !run("awake") {
+		t.Fatal("half-open circuit should retry the app")
+	}
+	if got := appStates[app.URL]; got.Circuit != CircuitClosed || got.Trips != 0 || got.ConsecutiveFailures != 0 {
+		t.Fatalf("state after success = %+v, want closed and reset", got)
+	}
+}
+
+func TestForEachAppKeepsOrder(t *testing.T) {
+	var apps []StreamlitApp
+	for i := 0; i < 6; i++ {
+		apps = append(apps, StreamlitApp{URL: "https://app" + strconv.Itoa(i) + ".streamlit.app"})
+	}
+	config := &Config{MaxConcurrency: 3}
+	timeoutFor := func(StreamlitApp) time.Duration { return time.Second }
+
+	// Earlier apps finish last
+	results := forEachApp(context.Background(), config, apps, timeoutFor, func(ctx context.Context, app StreamlitApp) WakeResult {
+		for i := range apps {
+			if apps[i].URL == app.URL {
+				time.Sleep(time.Duration(len(apps)-i) * 10 * time.Millisecond)
+			}
+		}
+		return WakeResult{URL: app.URL, Status: "awake"}
+	})
+
+	for i, result := range results {
+		if result.URL != apps[i].URL {
+			t.Fatalf("results[%d].URL = %s, want %s", i, result.URL, apps[i].URL)
+		}
+	}
+}
+
+func TestWithRetriesSkipsPermanentFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		result WakeResult
+	}{
+		{"hibernating", WakeResult{Status: "hibernating"}},
+		{"timeout", WakeResult{Status: "error", Err: ErrTimeout}},
+		{"bad script output", WakeResult{Status: "error", Err: ErrBadScriptOutput}},
+		{"success", WakeResult{Status: "awake"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			wake := withRetries(3, func(context.Context, StreamlitApp) WakeResult {
+				calls++
+				return tt.result
+			})
+			if result := wake(context.Background(), StreamlitApp{}); result.Attempts != 1 || calls != 1 {
+				t.Fatalf("attempts = %d, calls = %d, want 1", result.Attempts, calls)
+			}
+		})
+	}
+}
+
+func TestWakeAppsFallsBackToBrowser(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("fallback needs python3")
+	}
+
+	browser := &fakeWaker{statuses: map[string]string{
+		"https://b.streamlit.app": "woken_up",
+		"https://c.streamlit.app": "already_awake",
+	}}
+	direct := &fakeWaker{statuses: map[string]string{
+		"https://a.streamlit.app": "awake",
+		"https://b.streamlit.app": "hibernating",
+		"https://c.streamlit.app": "awake",
+	}}
+	saved := newWakers
+	newWakers = func(*Config) (Waker, Waker) { return browser, direct }
+	defer func() { newWakers = saved }()
+
+	config := &Config{Mode: ModeHTTP, MinPython: "3.0"}
+	apps := []StreamlitApp{
+		{URL: "https://a.streamlit.app"},
+		{URL: "https://b.streamlit.app"},
+		{URL: "https://c.streamlit.app", ExpectedText: "Dashboard"},
+	}
+	results, err := wakeApps(context.Background(), config, apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCalls := [][]string{{"https://b.streamlit.app", "https://c.streamlit.app"}}
+	if !reflect.DeepEqual(browser.calls, wantCalls) {
+		t.Fatalf("browser woke %v, want %v", browser.calls, wantCalls)
+	}
+	var statuses []string
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	if want := []string{"awake", "woken_up", "already_awake"}; !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("statuses = %v, want %v", statuses, want)
+	}
+}
+
+func TestFetchAppClassifies(t *testing.T) {
+	tests := []struct {
+		name   string
+		page   string
+		health string
+		want   string
+	}{
+		{"sleep page", "<p>Yes, get this app back up!</p>", "", "hibernating"},
+		{"health ok", "<div id=root></div>", "ok", "awake"},
+		{"no health endpoint", "<div id=root></div>", "", "reachable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/_stcore/health" {
+					if tt.health == "" {
+						http.NotFound(w, r)
+						return
+					}
+					w.Write([]byte(tt.health))
+					return
+				}
+				w.Write([]byte(tt.page))
+			}))
+			defer server.Close()
+
+			result, _ := fetchApp(context.Background(), server.Client(), StreamlitApp{URL: server.URL + "/"})
+			if result.Status != tt.want {
+				t.Fatalf("status = %s (%s), want %s", result.Status, result.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerRejectsUnauthenticatedAppList(t *testing.T) {
+	t.Setenv("CRON_AUTH_TOKEN", "")
+	t.Setenv("CRON_SECRET", "")
+	t.Setenv("STREAMLIT_APPS", `["https://a.streamlit.app"]`)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/cron", strings.NewReader(`["https://example.com"]`))
+	Handler(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+}
+
+func TestWithWakeError(t *testing.T) {
+	tests := []struct {
+		name        string
+		result      WakeResult
+		wantFailed  bool
+		wantTimeout bool
+	}{
+		{"success", WakeResult{Status: "woken_up"}, false, false},
+		{"message only", WakeResult{Status: "wake_failed", Message: "button not found"}, true, false},
+		{"timeout", WakeResult{Status: "error", Err: ErrTimeout}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := withWakeError(tt.result).Err
+			if got := errors.Is(err, ErrWakeFailed); got != tt.wantFailed {
+				t.Fatalf("errors.Is(%v, ErrWakeFailed) = %v, want %v", err, got, tt.wantFailed)
+			}
+			if got := errors.Is(err, ErrTimeout); got != tt.wantTimeout {
+				t.Fatalf("errors.Is(%v, ErrTimeout) = %v, want %v", err, got, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestProbeAppSetsWakeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	config := &Config{MaxConcurrency: 1}
+	results := forEachApp(context.Background(), config, []StreamlitApp{{URL: server.URL}}, func(StreamlitApp) time.Duration { return time.Second }, probeApp)
+	var wakeErr *WakeError
+	if !errors.As(results[0].Err, &wakeErr) || wakeErr.URL != server.URL {
+		t.Fatalf("Err = %v, want a *WakeError for %s", results[0].Err, server.URL)
+	}
+}
+
+func TestLoadConfigBoundsDelays(t *testing.T) {
+	tests := []struct {
+		env, value string
+		wantErr    bool
+	}{
+		{"WAKE_JITTER_SECONDS", "30", false},
+		{"WAKE_JITTER_SECONDS", "56", true},
+		{"WAKE_JITTER_SECONDS", "9223372036854775807", true},
+		{"WAKE_STAGGER_SECONDS", "5", false},
+		{"WAKE_STAGGER_SECONDS", "3600", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv("STREAMLIT_APPS", `["https://a.streamlit.app"]`)
+			t.Setenv(tt.env, tt.value)
+			if _, err := loadConfig(); (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePythonVersion(t *testing.T) {
+	tests := []struct {
+		version    string
+		major, min int
+		wantErr    bool
+	}{
+		{"3.11.4", 3, 11, false},
+		{"3.8", 3, 8, false},
+		{"3", 0, 0, true},
+		{"three.eight", 0, 0, true},
+	}
+	for _, tt := range tests {
+		major, minor, err := parsePythonVersion(tt.version)
+		if (err != nil) != tt.wantErr || major != tt.major || minor != tt.min {
+			t.Errorf("parsePythonVersion(%q) = %d, %d, %v", tt.version, major, minor, err)
+		}
+	}
+}
+
+func TestParseWaitStrategy(t *testing.T) {
+	tests := []struct {
+		strategy, waitUntil, selector string
+		wantErr                       bool
+	}{
+		{"networkidle", "networkidle", "", false},
+		{"selector: #root", "domcontentloaded", "#root", false},
+		{"selector:", "", "", true},
+		{"idle", "", "", true},
+	}
+	for _, tt := range tests {
+		waitUntil, selector, err := parseWaitStrategy(tt.strategy)
+		if (err != nil) != tt.wantErr || waitUntil != tt.waitUntil || selector != tt.selector {
+			t.Errorf("parseWaitStrategy(%q) = %q, %q, %v", tt.strategy, waitUntil, selector, err)
+		}
+	}
+}
+
+func TestParsePythonDeps(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"playwright==1.44.0, requests", []string{"playwright==1.44.0", "requests"}, false},
+		{"requests", nil, true},
+		{" , ", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePythonDeps(tt.value)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePythonDeps(%q) = %v, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestParseScriptResults(t *testing.T) {
+	lines := []string{
+		"Launching chromium",
+		`{"url": "https://a.streamlit.app", "status": "woken_up", "message": "clicked"}`,
+		`{"url": "https://b.streamlit.app", "status": "sleepy"}`,
+	}
+	results, err := parseScriptResults(lines)
+	if !errors.Is(err, ErrBadScriptOutput) {
+		t.Fatalf("err = %v, want ErrBadScriptOutput for the unsupported status", err)
+	}
+	if len(results) != 1 || results["https://a.streamlit.app"].Status != "woken_up" {
+		t.Fatalf("results = %+v, want only the woken_up line", results)
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	got := redactHeaders(map[string]string{"Authorization": "Bearer x", "X-Api-Key": "k", "Accept": "text/html"})
+	want := map[string]string{"Authorization": "[redacted]", "X-Api-Key": "[redacted]", "Accept": "text/html"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("redactHeaders() = %v, want %v", got, want)
+	}
+}
+
+func TestAppStatesRoundTrip(t *testing.T) {
+	appStates = map[string]*AppState{}
+	defer func() { appStates = map[string]*AppState{} }()
+
+	now := time.Now()
+	recordResults([]WakeResult{
+		{URL: "https://a.streamlit.app", Status: "woken_up"},
+		{URL: "https://b.streamlit.app", Status: "error"},
+	}, now, 0, 0)
+
+	path := t.TempDir() + "/state.json"
+	if err := saveAppStates(path); err != nil {
+		t.Fatal(err)
+	}
+	appStates = map[string]*AppState{}
+	if err := loadAppStates(path); err != nil {
+		t.Fatal(err)
+	}
+
+	apps := []StreamlitApp{{URL: "https://a.streamlit.app"}, {URL: "https://b.streamlit.app"}}
+	wake, recent := passRecent(apps, time.Minute, now.Add(30*time.Second))
+	if len(recent) != 1 || recent[0].URL != apps[0].URL || recent[0].Status != "recently_woken" {
+		t.Fatalf("recent = %+v, want only the woken app", recent)
+	}
+	if len(wake) != 1 || wake[0].URL != apps[1].URL {
+		t.Fatalf("wake = %+v, want only the failed app", wake)
+	}
+	if wake, _ := passRecent(apps, time.Minute, now.Add(2*time.Minute)); len(wake) != 2 {
+		t.Fatalf("wake after ttl = %+v, want both apps", wake)
+	}
+}
+
+func TestDeadLettersAndHistory(t *testing.T) {
+	appStates = map[string]*AppState{}
+	defer func() { appStates = map[string]*AppState{} }()
+
+	dir := t.TempDir()
+	failed := []WakeResult{{URL: "https://a.streamlit.app", Status: "wake_failed", Message: "no button"}}
+	recordResults(failed, time.Now(), 0, 0)
+	if err := appendDeadLetters(dir+"/dead.jsonl", "2024-01-01 00:00:00", failed); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readDeadLetters(dir + "/dead.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Attempts != 1 || entries[0].ConsecutiveFailures != 1 {
+		t.Fatalf("dead letters = %+v, want one entry with 1 attempt and 1 failure", entries)
+	}
+
+	for _, success := range []bool{true, false} {
+		if err := appendHistory(dir+"/history.jsonl", newRunRecord("2024-01-01 00:00:00", success, time.Second, failed)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last, err := readLastRun(dir + "/history.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || last.Success {
+		t.Fatalf("last run = %+v, want the unsuccessful second record", last)
+	}
+}
+
+func TestRenderMessage(t *testing.T) {
+	summary := newRunSummary("2024-01-01 00:00:00", time.Second, []WakeResult{
+		{URL: "https://a.streamlit.app", Status: "woken_up"},
+		{URL: "https://b.streamlit.app", Status: "error"},
+	}, nil)
+	got, err := renderMessage("test", "{{range .Results}}{{if failed .}}{{.URL}}{{end}}{{end}}", newWebhookPayload(summary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "https://b.streamlit.app" {
+		t.Fatalf("renderMessage() = %q, want only the failed app", got)
+	}
+	if _, err := renderMessage("test", "{{.Missing", nil); err == nil {
+		t.Fatal("renderMessage() accepted a broken template")
+	}
+}
+
+func TestNormalizeCollapsesDuplicates(t *testing.T) {
+	config := &Config{Apps: []StreamlitApp{
+		{Name: "first", URL: "https://x.streamlit.app/"},
+		{URL: "http://X.streamlit.app"},
+		{URL: "y.streamlit.app"},
+	}}
+	config.Normalize()
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var urls []string
+	for _, app := range config.Apps {
+		urls = append(urls, app.URL)
+	}
+	if want := []string{"https://x.streamlit.app", "https://y.streamlit.app"}; !reflect.DeepEqual(urls, want) {
+		t.Fatalf("urls = %v, want %v", urls, want)
+	}
+}
+
+func TestHandlerClampsTimeout(t *testing.T) {
+	t.Setenv("STREAMLIT_APPS", `["https://a.streamlit.app"]`)
+
+	tests := []struct {
+		timeout, want string
+	}{
+		{"20", "20s"},
+		{"3600", "55s"},
+		{"9223372036854775807", "55s"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		Handler(w, httptest.NewRequest(http.MethodGet, "/api/cron?dry_run=1&timeout="+tt.timeout, nil))
+
+		var body struct {
+			Plan []PlanStep `json:"plan"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || len(body.Plan) != 1 {
+			t.Fatalf("timeout=%s: status %d, plan %+v, err %v", tt.timeout, w.Code, body.Plan, err)
+		}
+		if got := body.Plan[0].Timeout; got != tt.want {
+			t.Errorf("timeout=%s: planned timeout %s, want %s", tt.timeout, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/whonehuljain/keep-my-streamlit-apps-alive
+
+go 1.21
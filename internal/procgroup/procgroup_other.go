@@ -0,0 +1,11 @@
+//go:build !unix
+
+// Package procgroup stops a command together with the processes it spawned.
+package procgroup
+
+import "os/exec"
+
+// KillOnCancel leaves cmd's default cancellation, which kills only the
+// process itself. Process groups are Unix-only; this keeps the handler
+// building elsewhere, such as for vercel dev on Windows.
+func KillOnCancel(cmd *exec.Cmd) {}
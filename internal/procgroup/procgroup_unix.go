@@ -0,0 +1,20 @@
+//go:build unix
+
+// Package procgroup stops a command together with the processes it spawned.
+package procgroup
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// KillOnCancel configures cmd, which must come from exec.CommandContext and
+// not be started yet, to run in its own process group and makes cancellation
+// SIGKILL the whole group, so the browser processes python3 spawned die with
+// it instead of lingering.
+func KillOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}